@@ -0,0 +1,63 @@
+// Package eventbus provides a pluggable pub/sub backend that
+// session.Events can publish onto, so multiple bettercap instances
+// (e.g. one per monitor-mode radio, on different hosts) can share
+// sightings, deauth results and proxy script events over a common
+// topic instead of only keeping them in their own local session.
+package eventbus
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// replayBufferSize bounds how many recent events per topic a backend
+// keeps around for Since() to serve to late-joining subscribers.
+const replayBufferSize = 256
+
+// Event is a single pub/sub message. PublisherID and Seq identify
+// which instance published it and where it falls in that instance's
+// own stream, so a late-joining subscriber can tell it missed
+// messages and call Since to catch up.
+type Event struct {
+	Topic       string
+	PublisherID string
+	Seq         uint64
+	Data        json.RawMessage
+	Time        time.Time
+}
+
+// wireEvent is what actually goes out over NATS/Redis; Data is kept
+// as a raw JSON blob so the bus itself never needs to know the shape
+// of application payloads.
+type wireEvent struct {
+	PublisherID string          `json:"publisher_id"`
+	Seq         uint64          `json:"seq"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// EventBus is the pluggable pub/sub backend session.Events sits on
+// top of. The default is purely in-process; nats and redis
+// implementations let independent bettercap instances merge views.
+type EventBus interface {
+	// Publish marshals data to JSON and publishes it on topic, tagged
+	// with the publisher's id and its next sequence number.
+	Publish(topic string, publisherID string, seq uint64, data interface{}) error
+
+	// Subscribe returns a channel of Events published on topic from
+	// now on. The channel is closed when Close is called.
+	Subscribe(topic string) (<-chan Event, error)
+
+	// Since replays every buffered event on topic published by
+	// publisherID after seq, for subscribers that joined late.
+	Since(topic string, publisherID string, seq uint64) []Event
+
+	Close() error
+}
+
+func appendBounded(events []Event, event Event, max int) []Event {
+	events = append(events, event)
+	if len(events) > max {
+		events = events[len(events)-max:]
+	}
+	return events
+}
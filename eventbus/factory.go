@@ -0,0 +1,30 @@
+package eventbus
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// NewFromURL builds the EventBus backend named by rawurl's scheme:
+// nats://, redis://, or empty/local:// for the default in-process bus.
+func NewFromURL(rawurl string) (EventBus, error) {
+	if rawurl == "" {
+		return NewLocalBus(), nil
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "", "local":
+		return NewLocalBus(), nil
+	case "nats":
+		return NewNatsBus(rawurl)
+	case "redis":
+		return NewRedisBus(rawurl)
+	default:
+		return nil, fmt.Errorf("unsupported event bus url scheme: %s", u.Scheme)
+	}
+}
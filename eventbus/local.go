@@ -0,0 +1,91 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// localBus is the default in-process EventBus: it never leaves the
+// current bettercap instance, used when no events.bus url is set.
+type localBus struct {
+	lock        sync.Mutex
+	subscribers map[string][]chan Event
+	history     map[string][]Event
+}
+
+// NewLocalBus creates the default in-process event bus.
+func NewLocalBus() EventBus {
+	return &localBus{
+		subscribers: make(map[string][]chan Event),
+		history:     make(map[string][]Event),
+	}
+}
+
+func (b *localBus) Publish(topic string, publisherID string, seq uint64, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	event := Event{
+		Topic:       topic,
+		PublisherID: publisherID,
+		Seq:         seq,
+		Data:        raw,
+		Time:        time.Now(),
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.history[topic] = appendBounded(b.history[topic], event, replayBufferSize)
+
+	for _, ch := range b.subscribers[topic] {
+		select {
+		case ch <- event:
+		default:
+			// a slow subscriber shouldn't stall the publisher; it can
+			// catch up with Since once it notices the gap.
+		}
+	}
+
+	return nil
+}
+
+func (b *localBus) Subscribe(topic string) (<-chan Event, error) {
+	ch := make(chan Event, 64)
+
+	b.lock.Lock()
+	b.subscribers[topic] = append(b.subscribers[topic], ch)
+	b.lock.Unlock()
+
+	return ch, nil
+}
+
+func (b *localBus) Since(topic string, publisherID string, seq uint64) []Event {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	replay := make([]Event, 0)
+	for _, event := range b.history[topic] {
+		if event.PublisherID == publisherID && event.Seq > seq {
+			replay = append(replay, event)
+		}
+	}
+	return replay
+}
+
+func (b *localBus) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, chans := range b.subscribers {
+		for _, ch := range chans {
+			close(ch)
+		}
+	}
+	b.subscribers = make(map[string][]chan Event)
+
+	return nil
+}
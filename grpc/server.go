@@ -0,0 +1,319 @@
+// Package grpc exposes bettercap's session, module handlers and event
+// stream over gRPC (see proto/bettercap.proto), so external tools can
+// drive wlan.recon, http.proxy and friends programmatically instead of
+// only through the interactive REPL.
+//
+// proto/bettercap.pb.go is hand-written to match what protoc-gen-go
+// would produce, since protoc isn't available in every environment
+// this tree is built in; see that file's header before editing it.
+// Regenerate it for real once protoc is available with:
+//
+//	protoc --go_out=plugins=grpc:. proto/bettercap.proto
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/evilsocket/bettercap-ng/log"
+	"github.com/evilsocket/bettercap-ng/modules"
+	"github.com/evilsocket/bettercap-ng/proto"
+	"github.com/evilsocket/bettercap-ng/session"
+)
+
+// AuthTokenEnvVar is the environment variable clients' metadata
+// "authorization" value is checked against, mirroring how caplets
+// pick up secrets from the environment rather than from flags.
+const AuthTokenEnvVar = "BETTERCAP_GRPC_TOKEN"
+
+// DefaultAddress is what the gRPC control plane listens on absent a
+// "grpc set address" override.
+const DefaultAddress = "127.0.0.1:50051"
+
+// TLSConfig holds the certificate/key/CA paths needed for mTLS, which
+// is enabled by default: every client must present a certificate
+// signed by CAFile.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Server implements the Bettercap gRPC service on top of the session
+// it's attached to, dispatching into the same session.ModuleHandler
+// machinery the REPL uses. Like every other subsystem in this tree,
+// it's a session.SessionModule started and stopped from the REPL
+// rather than something the caller wires up by hand.
+type Server struct {
+	session.SessionModule
+
+	Address string
+	TLS     TLSConfig
+
+	token  string
+	server *grpc.Server
+}
+
+// NewServer creates a grpc module for s, registering the "grpc
+// on/off" and "grpc set ..." handlers used to configure and start it.
+func NewServer(s *session.Session) *Server {
+	srv := &Server{
+		SessionModule: session.NewSessionModule("grpc", s),
+		Address:       DefaultAddress,
+		token:         os.Getenv(AuthTokenEnvVar),
+	}
+
+	srv.AddHandler(session.NewModuleHandler("grpc on", "",
+		"Start the gRPC control plane.",
+		func(args []string) error {
+			return srv.Start()
+		}))
+
+	srv.AddHandler(session.NewModuleHandler("grpc off", "",
+		"Stop the gRPC control plane.",
+		func(args []string) error {
+			return srv.Stop()
+		}))
+
+	srv.AddHandler(session.NewModuleHandler("grpc set address ADDRESS", `grpc set address (.+)`,
+		"Set the host:port the gRPC control plane listens on.",
+		func(args []string) error {
+			srv.Address = args[0]
+			return nil
+		}))
+
+	srv.AddHandler(session.NewModuleHandler("grpc set tls.cert PATH", `grpc set tls\.cert (.+)`,
+		"Set the server certificate used for mTLS.",
+		func(args []string) error {
+			srv.TLS.CertFile = args[0]
+			return nil
+		}))
+
+	srv.AddHandler(session.NewModuleHandler("grpc set tls.key PATH", `grpc set tls\.key (.+)`,
+		"Set the server private key used for mTLS.",
+		func(args []string) error {
+			srv.TLS.KeyFile = args[0]
+			return nil
+		}))
+
+	srv.AddHandler(session.NewModuleHandler("grpc set tls.ca PATH", `grpc set tls\.ca (.+)`,
+		"Set the CA every client certificate must chain to.",
+		func(args []string) error {
+			srv.TLS.CAFile = args[0]
+			return nil
+		}))
+
+	return srv
+}
+
+func (s *Server) Name() string {
+	return "grpc"
+}
+
+func (s *Server) Description() string {
+	return "A gRPC control plane exposing the session, module handlers and event stream to external tools."
+}
+
+func (s *Server) Author() string {
+	return "Gianluca Braga <matrix86@protonmail.com>"
+}
+
+// Start begins serving the Bettercap gRPC service on s.Address using
+// mTLS per s.TLS, in the background.
+func (s *Server) Start() error {
+	if s.Running() {
+		return session.ErrAlreadyStarted
+	}
+
+	creds, err := s.buildTransportCredentials(s.TLS)
+	if err != nil {
+		return err
+	}
+
+	lis, err := newListener(s.Address)
+	if err != nil {
+		return err
+	}
+
+	s.server = grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.UnaryInterceptor(s.authUnaryInterceptor),
+		grpc.StreamInterceptor(s.authStreamInterceptor),
+	)
+
+	proto.RegisterBettercapServer(s.server, s)
+
+	log.Info("gRPC control plane listening on %s", s.Address)
+
+	return s.SetRunning(true, func() {
+		if err := s.server.Serve(lis); err != nil {
+			log.Debug("gRPC control plane stopped: %s", err)
+		}
+	})
+}
+
+// Stop gracefully shuts the gRPC control plane down. Unlike the
+// capture-loop modules, gRPC's Serve blocks on the listener rather
+// than polling Running(), so it needs an explicit GracefulStop to
+// unblock rather than just flipping the running flag.
+func (s *Server) Stop() error {
+	if err := s.SetRunning(false, nil); err != nil {
+		return err
+	}
+
+	if s.server != nil {
+		s.server.GracefulStop()
+	}
+
+	return nil
+}
+
+func (s *Server) buildTransportCredentials(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caRaw, err := ioutil.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caRaw) {
+		return nil, errors.New("could not parse CA certificate")
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    caPool,
+	}), nil
+}
+
+func (s *Server) authorize(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return errors.New("missing peer authentication info")
+	}
+
+	md, ok := metadataFromContext(ctx)
+	if !ok || len(md["authorization"]) == 0 || md["authorization"][0] != s.token {
+		return errors.New("invalid or missing authorization token")
+	}
+
+	return nil
+}
+
+func (s *Server) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// ListModules mirrors the "help" REPL command: every registered
+// module, its metadata and whether it's currently running.
+func (s *Server) ListModules(ctx context.Context, _ *proto.Empty) (*proto.ModuleList, error) {
+	list := &proto.ModuleList{}
+
+	for _, m := range s.Session.Modules {
+		list.Modules = append(list.Modules, &proto.Module{
+			Name:        m.Name(),
+			Description: m.Description(),
+			Author:      m.Author(),
+			Running:     m.Running(),
+		})
+	}
+
+	return list, nil
+}
+
+// RunCommand dispatches req.Command through session.ModuleHandler,
+// exactly as if it had been typed interactively.
+func (s *Server) RunCommand(ctx context.Context, req *proto.CommandRequest) (*proto.CommandResponse, error) {
+	if err := s.Session.Run(req.Command); err != nil {
+		return &proto.CommandResponse{Success: false, Error: err.Error()}, nil
+	}
+	return &proto.CommandResponse{Success: true}, nil
+}
+
+// Subscribe streams session.Events matching filter.TagPrefix for as
+// long as the client stays connected.
+func (s *Server) Subscribe(filter *proto.EventFilter, stream proto.Bettercap_SubscribeServer) error {
+	listener := s.Session.Events.Listen()
+	defer s.Session.Events.Unlisten(listener)
+
+	for {
+		select {
+		case event := <-listener:
+			if filter.TagPrefix != "" && !hasPrefix(event.Tag, filter.TagPrefix) {
+				continue
+			}
+
+			dataJSON, err := json.Marshal(event.Data)
+			if err != nil {
+				log.Error("Could not serialize event %s: %s", event.Tag, err)
+				continue
+			}
+
+			if err := stream.Send(&proto.Event{
+				Tag:      event.Tag,
+				Time:     event.Time.Format("2006-01-02 15:04:05"),
+				DataJson: string(dataJSON),
+			}); err != nil {
+				return err
+			}
+
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// LoadProxyScript hot-loads a ProxyScript into the running http.proxy
+// module without restarting it, returning compilation errors as-is.
+func (s *Server) LoadProxyScript(ctx context.Context, req *proto.ProxyScriptRequest) (*proto.ProxyScriptResponse, error) {
+	path := req.Path
+	if path == "" {
+		tmp, err := writeTempScript(req.Source)
+		if err != nil {
+			return &proto.ProxyScriptResponse{Success: false, Error: err.Error()}, nil
+		}
+		path = tmp
+		// SetProxyScript reads the script's content once, synchronously,
+		// so the temp file is only needed for the duration of this call.
+		defer os.Remove(path)
+	}
+
+	if err := modules.SetProxyScript(s.Session, path); err != nil {
+		return &proto.ProxyScriptResponse{Success: false, Error: err.Error()}, nil
+	}
+
+	return &proto.ProxyScriptResponse{Success: true}, nil
+}
+
+func hasPrefix(tag, prefix string) bool {
+	return len(tag) >= len(prefix) && tag[:len(prefix)] == prefix
+}
@@ -0,0 +1,35 @@
+package grpc
+
+import (
+	"io/ioutil"
+	"net"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func newListener(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func metadataFromContext(ctx context.Context) (metadata.MD, bool) {
+	return metadata.FromIncomingContext(ctx)
+}
+
+// writeTempScript persists an inline script source to a temp file so
+// it can be loaded through the same path-based LoadProxyScript every
+// other caller uses.
+func writeTempScript(source string) (string, error) {
+	f, err := ioutil.TempFile("", "bettercap-proxy-script-*.js")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(source); err != nil {
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -1,8 +1,8 @@
 package modules
 
 import (
+	"errors"
 	"io/ioutil"
-	"net/http"
 	"sync"
 
 	"github.com/evilsocket/bettercap-ng/log"
@@ -11,6 +11,40 @@ import (
 	"github.com/robertkrimen/otto"
 )
 
+// proxyModuleName is the session module name embedders (e.g. the
+// grpc control plane) look up to hot-load a script into the running
+// HTTP proxy.
+const proxyModuleName = "http.proxy"
+
+// ActiveProxy is implemented by the http.proxy module; callers
+// outside this package only need this interface, not the concrete
+// proxy type, to reach its script chain at runtime.
+type ActiveProxy interface {
+	ScriptChain() *ProxyScriptChain
+}
+
+// SetProxyScript compiles path and adds it to the running http.proxy
+// module's script chain, reloading it in place if it's already part
+// of the chain.
+func SetProxyScript(sess *session.Session, path string) error {
+	for _, m := range sess.Modules {
+		if m.Name() == proxyModuleName {
+			p, ok := m.(ActiveProxy)
+			if !ok {
+				continue
+			}
+
+			chain := p.ScriptChain()
+			if chain.Has(path) {
+				return chain.Reload(path)
+			}
+			return chain.Add(path)
+		}
+	}
+
+	return errors.New("http.proxy module not found or not running")
+}
+
 type ProxyScript struct {
 	Path   string
 	Source string
@@ -125,85 +159,105 @@ func (s *ProxyScript) hasCallback(name string) bool {
 	return has
 }
 
-func (s *ProxyScript) doRequestDefines(req *http.Request) (err error, jsres *JSResponse) {
-	// convert request and define empty response to be optionally filled
-	jsreq := NewJSRequest(req)
-	if err = s.VM.Set("req", &jsreq); err != nil {
+// Matches runs the script's optional matches(req) predicate so it can
+// be scoped to hostnames/paths without every callback wrapping itself
+// in an if. Scripts that don't define matches() always match.
+func (s *ProxyScript) Matches(jsreq *JSRequest) bool {
+	if !s.hasCallback("matches") {
+		return true
+	}
+
+	s.gil.Lock()
+	defer s.gil.Unlock()
+
+	if err := s.VM.Set("req", jsreq); err != nil {
 		log.Error("Error while defining request: %s", err)
-		return
+		return true
 	}
 
-	jsres = &JSResponse{}
-	if err = s.VM.Set("res", jsres); err != nil {
-		log.Error("Error while defining response: %s", err)
-		return
+	v, err := s.VM.Run("matches(req)")
+	if err != nil {
+		log.Error("Error while executing matches callback: %s", err)
+		return true
 	}
 
-	return
+	matched, err := v.ToBoolean()
+	if err != nil {
+		return true
+	}
+
+	return matched
 }
 
-func (s *ProxyScript) doResponseDefines(res *http.Response) (err error, jsres *JSResponse) {
-	// convert both request and response
-	jsreq := NewJSRequest(res.Request)
-	if err = s.VM.Set("req", jsreq); err != nil {
-		log.Error("Error while defining request: %s", err)
+// Unload runs the script's optional onUnload() callback, giving it a
+// chance to release resources before it's dropped from the chain or
+// replaced by a reloaded copy of itself.
+func (s *ProxyScript) Unload() {
+	if !s.hasCallback("onUnload") {
 		return
 	}
 
-	jsres = NewJSResponse(res)
-	if err = s.VM.Set("res", jsres); err != nil {
-		log.Error("Error while defining response: %s", err)
-		return
-	}
+	s.gil.Lock()
+	defer s.gil.Unlock()
 
-	return
+	if _, err := s.VM.Run("onUnload()"); err != nil {
+		log.Error("Error while executing onUnload callback: %s", err)
+	}
 }
 
-func (s *ProxyScript) OnRequest(req *http.Request) *JSResponse {
-	if s.onRequestScript != nil {
-		s.gil.Lock()
-		defer s.gil.Unlock()
+// OnRequest runs the script's onRequest callback, if defined, against
+// the given request/response pair. jsreq/jsres are owned by the
+// ProxyScriptChain and shared across every script in the chain, so
+// later scripts observe whatever this one mutated.
+func (s *ProxyScript) OnRequest(jsreq *JSRequest, jsres *JSResponse) error {
+	if s.onRequestScript == nil {
+		return nil
+	}
 
-		err, jsres := s.doRequestDefines(req)
-		if err != nil {
-			log.Error("Error while running bootstrap definitions: %s", err)
-			return nil
-		}
+	s.gil.Lock()
+	defer s.gil.Unlock()
 
-		_, err = s.VM.Run(s.onRequestScript)
-		if err != nil {
-			log.Error("Error while executing onRequest callback: %s", err)
-			return nil
-		}
+	if err := s.VM.Set("req", jsreq); err != nil {
+		log.Error("Error while defining request: %s", err)
+		return err
+	}
 
-		if jsres.wasUpdated == true {
-			return jsres
-		}
+	if err := s.VM.Set("res", jsres); err != nil {
+		log.Error("Error while defining response: %s", err)
+		return err
+	}
+
+	if _, err := s.VM.Run(s.onRequestScript); err != nil {
+		log.Error("Error while executing onRequest callback: %s", err)
+		return err
 	}
 
 	return nil
 }
 
-func (s *ProxyScript) OnResponse(res *http.Response) *JSResponse {
-	if s.onResponseScript != nil {
-		s.gil.Lock()
-		defer s.gil.Unlock()
+// OnResponse runs the script's onResponse callback, if defined,
+// against the given request/response pair (see OnRequest).
+func (s *ProxyScript) OnResponse(jsreq *JSRequest, jsres *JSResponse) error {
+	if s.onResponseScript == nil {
+		return nil
+	}
 
-		err, jsres := s.doResponseDefines(res)
-		if err != nil {
-			log.Error("Error while running bootstrap definitions: %s", err)
-			return nil
-		}
+	s.gil.Lock()
+	defer s.gil.Unlock()
 
-		_, err = s.VM.Run(s.onResponseScript)
-		if err != nil {
-			log.Error("Error while executing onRequest callback: %s", err)
-			return nil
-		}
+	if err := s.VM.Set("req", jsreq); err != nil {
+		log.Error("Error while defining request: %s", err)
+		return err
+	}
 
-		if jsres.wasUpdated == true {
-			return jsres
-		}
+	if err := s.VM.Set("res", jsres); err != nil {
+		log.Error("Error while defining response: %s", err)
+		return err
+	}
+
+	if _, err := s.VM.Run(s.onResponseScript); err != nil {
+		log.Error("Error while executing onResponse callback: %s", err)
+		return err
 	}
 
 	return nil
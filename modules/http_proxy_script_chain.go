@@ -0,0 +1,262 @@
+package modules
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/evilsocket/bettercap-ng/log"
+	"github.com/evilsocket/bettercap-ng/session"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ProxyScriptChain owns an ordered list of ProxyScripts and runs a
+// request/response through all of them in sequence, each seeing the
+// mutations the previous one made to the shared JSRequest/JSResponse.
+// It also watches every loaded script's file and hot-reloads it on
+// change.
+type ProxyScriptChain struct {
+	sess    *session.Session
+	gil     *sync.Mutex
+	scripts []*ProxyScript
+	watcher *fsnotify.Watcher
+}
+
+// NewProxyScriptChain creates an empty chain for sess and starts
+// watching for file changes in loaded scripts, if a watcher can be
+// created.
+func NewProxyScriptChain(sess *session.Session) *ProxyScriptChain {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warning("Could not start proxy script watcher, hot-reload on change is disabled: %s", err)
+		watcher = nil
+	}
+
+	chain := &ProxyScriptChain{
+		sess:    sess,
+		gil:     &sync.Mutex{},
+		scripts: make([]*ProxyScript, 0),
+		watcher: watcher,
+	}
+
+	if watcher != nil {
+		go chain.watchLoop()
+	}
+
+	return chain
+}
+
+// RegisterHandlers adds the http.proxy.script.add/remove/reload
+// commands to m, the http.proxy module owning this chain.
+func (c *ProxyScriptChain) RegisterHandlers(m *session.SessionModule) {
+	m.AddHandler(session.NewModuleHandler("http.proxy.script.add PATH", `http\.proxy\.script\.add (.+)`,
+		"Add a proxy script to the chain.",
+		func(args []string) error {
+			return c.Add(args[0])
+		}))
+
+	m.AddHandler(session.NewModuleHandler("http.proxy.script.remove PATH", `http\.proxy\.script\.remove (.+)`,
+		"Remove a proxy script from the chain.",
+		func(args []string) error {
+			return c.Remove(args[0])
+		}))
+
+	m.AddHandler(session.NewModuleHandler("http.proxy.script.reload PATH", `http\.proxy\.script\.reload (.+)`,
+		"Recompile and reload a proxy script already in the chain.",
+		func(args []string) error {
+			return c.Reload(args[0])
+		}))
+}
+
+func (c *ProxyScriptChain) indexOf(path string) int {
+	for i, s := range c.scripts {
+		if s.Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// Has reports whether path is already loaded into the chain.
+func (c *ProxyScriptChain) Has(path string) bool {
+	c.gil.Lock()
+	defer c.gil.Unlock()
+	return c.indexOf(path) != -1
+}
+
+// Add compiles path and appends it to the end of the chain.
+func (c *ProxyScriptChain) Add(path string) error {
+	err, script := LoadProxyScript(path, c.sess)
+	if err != nil {
+		return err
+	}
+
+	c.gil.Lock()
+	defer c.gil.Unlock()
+
+	if c.indexOf(path) != -1 {
+		return fmt.Errorf("proxy script %s is already loaded", path)
+	}
+
+	c.scripts = append(c.scripts, script)
+
+	if c.watcher != nil {
+		if err := c.watcher.Add(path); err != nil {
+			log.Warning("Could not watch %s for changes: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Remove drops path from the chain and runs its onUnload callback,
+// under gil only for the splice itself so a slow or hanging onUnload
+// doesn't stall in-flight requests/responses, same as Reload.
+func (c *ProxyScriptChain) Remove(path string) error {
+	c.gil.Lock()
+
+	idx := c.indexOf(path)
+	if idx == -1 {
+		c.gil.Unlock()
+		return fmt.Errorf("proxy script %s is not loaded", path)
+	}
+
+	if c.watcher != nil {
+		c.watcher.Remove(path)
+	}
+
+	old := c.scripts[idx]
+	c.scripts = append(c.scripts[:idx], c.scripts[idx+1:]...)
+
+	c.gil.Unlock()
+
+	old.Unload()
+
+	return nil
+}
+
+// Reload recompiles path and swaps it in at the same chain position,
+// under gil so an in-flight request/response sees either the old
+// script or the new one, never a half-loaded one. The old copy's
+// onUnload is run after the swap.
+func (c *ProxyScriptChain) Reload(path string) error {
+	err, fresh := LoadProxyScript(path, c.sess)
+	if err != nil {
+		return err
+	}
+
+	c.gil.Lock()
+	idx := c.indexOf(path)
+	if idx == -1 {
+		c.gil.Unlock()
+		return fmt.Errorf("proxy script %s is not loaded", path)
+	}
+
+	old := c.scripts[idx]
+	c.scripts[idx] = fresh
+	c.gil.Unlock()
+
+	old.Unload()
+
+	return nil
+}
+
+func (c *ProxyScriptChain) watchLoop() {
+	for event := range c.watcher.Events {
+		if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+			continue
+		}
+
+		if err := c.Reload(event.Name); err != nil {
+			log.Error("Could not reload proxy script %s: %s", event.Name, err)
+		} else {
+			log.Info("Reloaded proxy script %s", event.Name)
+		}
+	}
+}
+
+func (c *ProxyScriptChain) snapshot() []*ProxyScript {
+	c.gil.Lock()
+	defer c.gil.Unlock()
+
+	scripts := make([]*ProxyScript, len(c.scripts))
+	copy(scripts, c.scripts)
+	return scripts
+}
+
+// ProxyScriptEvent is recorded to session.Events (and, through
+// wlan.recon's runSessionEventBridge, the event bus) for the first
+// script in the chain that marks the response as updated, during
+// either the request or the response pass; changes a script makes to
+// the request alone, and updates by later scripts, record nothing.
+type ProxyScriptEvent struct {
+	Script string
+	Method string
+	URL    string
+}
+
+// OnRequest runs req through every script that matches it, in order,
+// and returns the accumulated response if any script updated it.
+func (c *ProxyScriptChain) OnRequest(req *http.Request) *JSResponse {
+	jsreq := NewJSRequest(req)
+	jsres := &JSResponse{}
+
+	for _, s := range c.snapshot() {
+		if !s.Matches(&jsreq) {
+			continue
+		}
+
+		wasUpdated := jsres.wasUpdated
+		if err := s.OnRequest(&jsreq, jsres); err != nil {
+			continue
+		}
+
+		if jsres.wasUpdated && !wasUpdated {
+			c.sess.Events.Add("http.proxy.script.request", ProxyScriptEvent{
+				Script: s.Path,
+				Method: req.Method,
+				URL:    req.URL.String(),
+			})
+		}
+	}
+
+	if jsres.wasUpdated {
+		return jsres
+	}
+
+	return nil
+}
+
+// OnResponse runs res through every script that matches its request,
+// in order, and returns the accumulated response if any script
+// updated it.
+func (c *ProxyScriptChain) OnResponse(res *http.Response) *JSResponse {
+	jsreq := NewJSRequest(res.Request)
+	jsres := NewJSResponse(res)
+
+	for _, s := range c.snapshot() {
+		if !s.Matches(&jsreq) {
+			continue
+		}
+
+		wasUpdated := jsres.wasUpdated
+		if err := s.OnResponse(&jsreq, jsres); err != nil {
+			continue
+		}
+
+		if jsres.wasUpdated && !wasUpdated {
+			c.sess.Events.Add("http.proxy.script.response", ProxyScriptEvent{
+				Script: s.Path,
+				Method: res.Request.Method,
+				URL:    res.Request.URL.String(),
+			})
+		}
+	}
+
+	if jsres.wasUpdated {
+		return jsres
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package modules
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/evilsocket/bettercap-ng/log"
+	"github.com/evilsocket/bettercap-ng/session"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// BeaconInterval is the standard 802.11 beacon interval of 100 TU
+// (1 TU = 1.024 ms), i.e. roughly every 102.4 ms.
+const BeaconInterval = 102400 * time.Microsecond
+
+type WAPEncryption int
+
+const (
+	WAPEncryptionOpen WAPEncryption = iota
+	WAPEncryptionWPA2
+)
+
+// WAP injects beacon and probe-response frames for a synthesized
+// SSID, turning the monitor-mode interface into a rogue access point
+// / evil twin. It reuses the radiotap+Dot11 serialization helpers
+// wlan.recon uses for deauth.
+type WAP struct {
+	session.SessionModule
+
+	SSID       string
+	BSSID      net.HardwareAddr
+	Channel    int
+	Encryption WAPEncryption
+	Karma      bool
+
+	Handle       *pcap.Handle
+	BroadcastMac []byte
+
+	// seq is the 802.11 sequence number stamped on every beacon/probe
+	// response, incremented from both beaconLoop and probeLoop, so it's
+	// accessed only through atomic ops.
+	seq uint32
+}
+
+func NewWAP(s *session.Session) *WAP {
+	w := &WAP{
+		SessionModule: session.NewSessionModule("wlan.ap", s),
+		SSID:          "Free WiFi",
+		BSSID:         make([]byte, 0),
+		Channel:       1,
+		Encryption:    WAPEncryptionOpen,
+	}
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap on", "",
+		"Start the rogue access point.",
+		func(args []string) error {
+			return w.Start()
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap off", "",
+		"Stop the rogue access point.",
+		func(args []string) error {
+			return w.Stop()
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap set ssid SSID", `wlan\.ap set ssid (.+)`,
+		"Set the SSID to advertise.",
+		func(args []string) error {
+			w.SSID = args[0]
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap set bssid MAC", "wlan.ap set bssid "+MAC48Validator,
+		"Set the BSSID to advertise the SSID from.",
+		func(args []string) error {
+			var err error
+			w.BSSID, err = net.ParseMAC(args[0])
+			return err
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap set channel CHANNEL", `wlan\.ap set channel (\d+)`,
+		"Set the channel to advertise the SSID on.",
+		func(args []string) error {
+			channel, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			w.Channel = channel
+
+			if w.Running() {
+				iface := w.Session.Interface.Name()
+				if err := setInterfaceChannel(iface, channel); err != nil {
+					log.Warning("Could not set %s to channel %d: %s", iface, channel, err)
+				}
+			}
+
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap set encryption open|wpa2", `wlan\.ap set encryption (open|wpa2)`,
+		"Set the advertised encryption (open or wpa2).",
+		func(args []string) error {
+			if args[0] == "wpa2" {
+				w.Encryption = WAPEncryptionWPA2
+			} else {
+				w.Encryption = WAPEncryptionOpen
+			}
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap karma on", "",
+		"Answer every directed probe request with a probe response for the requested SSID (honeypot mode).",
+		func(args []string) error {
+			w.Karma = true
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.ap karma off", "",
+		"Only answer probe requests for the configured SSID.",
+		func(args []string) error {
+			w.Karma = false
+			return nil
+		}))
+
+	return w
+}
+
+func (w WAP) Name() string {
+	return "wlan.ap"
+}
+
+func (w WAP) Description() string {
+	return "A module to inject 802.11 beacon and probe-response frames to impersonate an access point."
+}
+
+func (w WAP) Author() string {
+	return "Gianluca Braga <matrix86@protonmail.com>"
+}
+
+// buildMgmtPkt wraps the given management body layer (beacon or
+// probe response, both of which carry Timestamp/Interval/Flags) in a
+// Dot11 header addressed as coming from BSSID, followed by the SSID
+// and DS-parameter-set information elements, plus an RSN element when
+// advertising wpa2.
+func (w *WAP) buildMgmtPkt(dst net.HardwareAddr, ssid string, _type layers.Dot11Type, body gopacket.SerializableLayer, seq uint16) []byte {
+	var dot11Layer layers.Dot11
+
+	dot11Layer.Address1 = dst
+	dot11Layer.Address2 = w.BSSID
+	dot11Layer.Address3 = w.BSSID
+	dot11Layer.Type = _type
+	dot11Layer.SequenceNumber = seq
+
+	ssidElement := &layers.Dot11InformationElement{
+		ID:     layers.Dot11InformationElementIDSSID,
+		Length: uint8(len(ssid)),
+		Info:   []byte(ssid),
+	}
+
+	dsElement := &layers.Dot11InformationElement{
+		ID:     layers.Dot11InformationElementIDDSSet,
+		Length: 1,
+		Info:   []byte{byte(w.Channel)},
+	}
+
+	elements := []gopacket.SerializableLayer{body, ssidElement, dsElement}
+	if w.Encryption == WAPEncryptionWPA2 {
+		// version 1, CCMP group and pairwise cipher, PSK key management
+		rsn := []byte{
+			0x01, 0x00,
+			0x00, 0x0f, 0xac, 0x04,
+			0x01, 0x00, 0x00, 0x0f, 0xac, 0x04,
+			0x01, 0x00, 0x00, 0x0f, 0xac, 0x02,
+			0x00, 0x00,
+		}
+		elements = append(elements, &layers.Dot11InformationElement{ID: layers.Dot11InformationElementIDRSNInfo, Length: uint8(len(rsn)), Info: rsn})
+	}
+
+	return serializeDot11Frame(&dot11Layer, elements...)
+}
+
+func (w *WAP) capabilityFlags() uint16 {
+	// ESS bit always set (we're an access point); privacy bit set
+	// whenever we're not advertising an open network.
+	var flags uint16 = 0x0001
+	if w.Encryption != WAPEncryptionOpen {
+		flags |= 0x0010
+	}
+	return flags
+}
+
+func (w *WAP) buildBeaconPkt(seq uint16) []byte {
+	beaconLayer := &layers.Dot11MgmtBeacon{
+		Timestamp: uint64(time.Since(w.Session.StartedAt) / time.Microsecond),
+		Interval:  100,
+		Flags:     w.capabilityFlags(),
+	}
+
+	return w.buildMgmtPkt(w.BroadcastMac, w.SSID, layers.Dot11TypeMgmtBeacon, beaconLayer, seq)
+}
+
+func (w *WAP) buildProbeRespPkt(dst net.HardwareAddr, ssid string, seq uint16) []byte {
+	probeLayer := &layers.Dot11MgmtProbeResp{
+		Timestamp: uint64(time.Since(w.Session.StartedAt) / time.Microsecond),
+		Interval:  100,
+		Flags:     w.capabilityFlags(),
+	}
+
+	return w.buildMgmtPkt(dst, ssid, layers.Dot11TypeMgmtProbeResp, probeLayer, seq)
+}
+
+func (w *WAP) nextSeq() uint16 {
+	return uint16(atomic.AddUint32(&w.seq, 1))
+}
+
+func (w *WAP) beaconLoop() {
+	ticker := time.NewTicker(BeaconInterval)
+	defer ticker.Stop()
+
+	for w.Running() {
+		if err := w.Handle.WritePacketData(w.buildBeaconPkt(w.nextSeq())); err != nil {
+			log.Warning("Could not send beacon for %s: %s", w.SSID, err)
+		}
+		<-ticker.C
+	}
+}
+
+// handleProbeRequest replies to broadcast probe requests and to
+// directed probe requests for our SSID; in karma mode, it replies to
+// every directed probe request regardless of the requested SSID.
+func (w *WAP) handleProbeRequest(packet gopacket.Packet) {
+	dot11Layer := packet.Layer(layers.LayerTypeDot11)
+	if dot11Layer == nil {
+		return
+	}
+
+	dot11, _ := dot11Layer.(*layers.Dot11)
+	if dot11.Type != layers.Dot11TypeMgmtProbeReq {
+		return
+	}
+
+	ssid := ""
+	if infoLayer := packet.Layer(layers.LayerTypeDot11InformationElement); infoLayer != nil {
+		if info, ok := infoLayer.(*layers.Dot11InformationElement); ok && info.ID == layers.Dot11InformationElementIDSSID {
+			ssid = string(info.Info)
+		}
+	}
+
+	if ssid != "" && ssid != w.SSID && w.Karma == false {
+		return
+	}
+
+	// broadcast probes get our SSID, directed ones the SSID they asked for
+	respSSID := w.SSID
+	if ssid != "" {
+		respSSID = ssid
+	}
+
+	if err := w.Handle.WritePacketData(w.buildProbeRespPkt(dot11.Address2, respSSID, w.nextSeq())); err != nil {
+		log.Warning("Could not send probe response to %s: %s", dot11.Address2, err)
+	}
+}
+
+func (w *WAP) probeLoop() {
+	src := gopacket.NewPacketSource(w.Handle, w.Handle.LinkType())
+	for packet := range src.Packets() {
+		if w.Running() == false {
+			break
+		}
+		w.handleProbeRequest(packet)
+	}
+}
+
+func (w *WAP) Configure() error {
+	var err error
+
+	w.BroadcastMac, _ = net.ParseMAC(BROADCAST_MAC)
+
+	inactive, err := pcap.NewInactiveHandle(w.Session.Interface.Name())
+	defer inactive.CleanUp()
+
+	if err = inactive.SetRFMon(true); err != nil {
+		return err
+	}
+
+	if err = inactive.SetSnapLen(65536); err != nil {
+		return err
+	}
+
+	if err = inactive.SetTimeout(pcap.BlockForever); err != nil {
+		return err
+	}
+
+	w.Handle, err = inactive.Activate()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (w *WAP) Start() error {
+	if w.Running() == true {
+		return session.ErrAlreadyStarted
+	} else if len(w.BSSID) == 0 {
+		return errors.New("BSSID is not set.")
+	} else if err := w.Configure(); err != nil {
+		return err
+	}
+
+	iface := w.Session.Interface.Name()
+	if err := setInterfaceChannel(iface, w.Channel); err != nil {
+		log.Warning("Could not set %s to channel %d, beacons will claim channel %d while transmitting on whatever channel the radio is actually on: %s", iface, w.Channel, w.Channel, err)
+	}
+
+	go w.beaconLoop()
+
+	w.SetRunning(true, func() {
+		defer w.Handle.Close()
+		w.probeLoop()
+	})
+
+	return nil
+}
+
+func (w *WAP) Stop() error {
+	return w.SetRunning(false, nil)
+}
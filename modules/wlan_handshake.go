@@ -0,0 +1,289 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/evilsocket/bettercap-ng/log"
+	"github.com/evilsocket/bettercap-ng/session"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// DefaultHandshakePath is where wlan.handshake.capture writes the
+// pcap file, absent a wlan.handshake.path override.
+const DefaultHandshakePath = "handshake.pcap"
+
+// HandshakeEvent is pushed to session.Events once a full (or
+// sufficient) 4-way handshake has been captured for a (BSSID, client)
+// pair, so the deauth loop can be stopped automatically.
+type HandshakeEvent struct {
+	BSSID  string
+	Client string
+	File   string
+}
+
+// eapolCapture tracks which of the 4-way handshake messages have been
+// seen for a given (BSSID, client) pair.
+type eapolCapture struct {
+	bssid    net.HardwareAddr
+	client   net.HardwareAddr
+	beaconed bool
+	seen     map[int]bool
+}
+
+func newEapolCapture(bssid net.HardwareAddr, client net.HardwareAddr) *eapolCapture {
+	return &eapolCapture{
+		bssid:  bssid,
+		client: client,
+		seen:   make(map[int]bool),
+	}
+}
+
+// complete is true once we've seen enough of the handshake to be
+// useful to hashcat/aircrack: all of M1-M4, or at least M1+M2.
+func (c *eapolCapture) complete() bool {
+	if c.seen[1] && c.seen[2] && c.seen[3] && c.seen[4] {
+		return true
+	}
+	return c.seen[1] && c.seen[2]
+}
+
+// Bit positions within the 2-byte Key Information field of an
+// EAPOL-Key frame (IEEE 802.11i 8.5.2). gopacket's EAPOL layer only
+// decodes Version/Type/Length and leaves the key descriptor as raw
+// payload, so these flags are read by hand instead of off a decoded
+// layers.EAPOLKey (gopacket has no such type).
+const (
+	eapolKeyInfoInstall = 1 << 6
+	eapolKeyInfoACK     = 1 << 7
+	eapolKeyInfoMIC     = 1 << 8
+	eapolKeyInfoSecure  = 1 << 9
+)
+
+// eapolMessageNumber classifies an EAPOL-Key frame as 1 to 4 of the
+// 4-way handshake based on its key info flags, or 0 if it doesn't
+// look like one of the four. payload is the EAPOL layer's raw
+// LayerPayload(): byte 0 is the descriptor type, bytes 1-2 are the
+// big-endian Key Information field.
+func eapolMessageNumber(payload []byte) int {
+	if len(payload) < 3 {
+		return 0
+	}
+
+	keyInfo := uint16(payload[1])<<8 | uint16(payload[2])
+
+	ack := keyInfo&eapolKeyInfoACK != 0
+	mic := keyInfo&eapolKeyInfoMIC != 0
+	secure := keyInfo&eapolKeyInfoSecure != 0
+	install := keyInfo&eapolKeyInfoInstall != 0
+
+	switch {
+	case ack && !mic:
+		return 1
+	case !ack && mic && !secure:
+		return 2
+	case ack && mic && secure && install:
+		return 3
+	case !ack && mic && secure:
+		return 4
+	}
+	return 0
+}
+
+func (w *WDiscovery) registerHandshakeHandlers() {
+	w.AddHandler(session.NewModuleHandler("wlan.handshake.capture on", "",
+		"Start capturing the EAPOL 4-way handshake for the configured target.",
+		func(args []string) error {
+			return w.StartHandshakeCapture()
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.handshake.capture off", "",
+		"Stop capturing the EAPOL 4-way handshake.",
+		func(args []string) error {
+			return w.StopHandshakeCapture()
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.handshake.path PATH", `wlan\.handshake\.path (.+)`,
+		"Set the pcap file the captured handshake is written to.",
+		func(args []string) error {
+			w.handshakeLock.Lock()
+			w.HandshakePath = args[0]
+			w.handshakeLock.Unlock()
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.handshake set target BSSID CLIENT",
+		`wlan\.handshake set target `+MAC48Validator+` `+MAC48Validator,
+		"Set the (BSSID, client) pair to watch for a 4-way handshake.",
+		func(args []string) error {
+			bssid, err := net.ParseMAC(args[0])
+			if err != nil {
+				return err
+			}
+
+			client, err := net.ParseMAC(args[1])
+			if err != nil {
+				return err
+			}
+
+			w.handshakeLock.Lock()
+			w.handshake = newEapolCapture(bssid, client)
+			w.handshakeLock.Unlock()
+			return nil
+		}))
+}
+
+// StartHandshakeCapture opens HandshakePath and starts writing EAPOL
+// frames matching the configured target to it.
+func (w *WDiscovery) StartHandshakeCapture() error {
+	w.handshakeLock.Lock()
+	target := w.handshake
+	capturing := w.HandshakeCapturing
+	path := w.HandshakePath
+	w.handshakeLock.Unlock()
+
+	if target == nil {
+		return errors.New("No handshake target set, use wlan.handshake set target BSSID CLIENT first.")
+	} else if capturing == true {
+		return errors.New("Handshake capture is already running.")
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(65536, layers.LinkTypeIEEE802_11Radio); err != nil {
+		file.Close()
+		return err
+	}
+
+	w.handshakeLock.Lock()
+	w.handshakeFile = file
+	w.handshakeWriter = writer
+	w.HandshakeCapturing = true
+	w.handshakeLock.Unlock()
+
+	log.Info("Capturing EAPOL handshake for %s / %s to %s", target.bssid, target.client, path)
+
+	return nil
+}
+
+// StopHandshakeCapture closes the pcap file being written, if any.
+func (w *WDiscovery) StopHandshakeCapture() error {
+	w.handshakeLock.Lock()
+	defer w.handshakeLock.Unlock()
+
+	if w.HandshakeCapturing == false {
+		return nil
+	}
+
+	w.HandshakeCapturing = false
+
+	if w.handshakeFile != nil {
+		w.handshakeFile.Close()
+		w.handshakeFile = nil
+	}
+	w.handshakeWriter = nil
+
+	return nil
+}
+
+// autoStartHandshakeCapture turns capture on without user interaction
+// whenever a deauth is sent against the currently configured target,
+// so users don't have to race the handshake by hand.
+func (w *WDiscovery) autoStartHandshakeCapture(ap net.HardwareAddr, client net.HardwareAddr) {
+	w.handshakeLock.Lock()
+	capturing := w.HandshakeCapturing
+	target := w.handshake
+	w.handshakeLock.Unlock()
+
+	if capturing == true || target == nil {
+		return
+	}
+
+	if bytes.Compare(ap, target.bssid) == 0 && bytes.Compare(client, target.client) == 0 {
+		if err := w.StartHandshakeCapture(); err != nil {
+			log.Warning("Could not auto start handshake capture: %s", err)
+		}
+	}
+}
+
+func (w *WDiscovery) writeHandshakeFrame(packet gopacket.Packet) error {
+	w.handshakeLock.Lock()
+	writer := w.handshakeWriter
+	w.handshakeLock.Unlock()
+
+	if writer == nil {
+		return errors.New("No handshake capture in progress.")
+	}
+
+	return writer.WritePacket(packet.Metadata().CaptureInfo, packet.Data())
+}
+
+// HandshakeScan watches the capture stream for EAPOL key frames that
+// belong to the configured (BSSID, client) pair and appends them to
+// the handshake pcap file, emitting a session event once enough of
+// the 4-way handshake has been observed.
+func (w *WDiscovery) HandshakeScan(packet gopacket.Packet) {
+	w.handshakeLock.Lock()
+	capturing := w.HandshakeCapturing
+	target := w.handshake
+	writer := w.handshakeWriter
+	path := w.HandshakePath
+	w.handshakeLock.Unlock()
+
+	if capturing == false || target == nil || writer == nil {
+		return
+	}
+
+	dot11Layer := packet.Layer(layers.LayerTypeDot11)
+	if dot11Layer == nil {
+		return
+	}
+	dot11, _ := dot11Layer.(*layers.Dot11)
+
+	fromTarget := bytes.Compare(dot11.Address1, target.bssid) == 0 && bytes.Compare(dot11.Address2, target.client) == 0
+	toTarget := bytes.Compare(dot11.Address1, target.client) == 0 && bytes.Compare(dot11.Address2, target.bssid) == 0
+	if !fromTarget && !toTarget {
+		return
+	}
+
+	eapolLayer := packet.Layer(layers.LayerTypeEAPOL)
+	if eapolLayer == nil {
+		return
+	}
+	eapol, _ := eapolLayer.(*layers.EAPOL)
+
+	msg := eapolMessageNumber(eapol.LayerPayload())
+	if msg == 0 {
+		return
+	}
+
+	if err := w.writeHandshakeFrame(packet); err != nil {
+		log.Error("Error while writing handshake frame: %s", err)
+		return
+	}
+
+	w.handshakeLock.Lock()
+	target.seen[msg] = true
+	complete := target.complete()
+	w.handshakeLock.Unlock()
+
+	log.Info("Captured EAPOL message %d/4 for %s / %s", msg, target.bssid, target.client)
+
+	if complete {
+		w.Session.Events.Add("wlan.handshake.captured", HandshakeEvent{
+			BSSID:  target.bssid.String(),
+			Client: target.client.String(),
+			File:   path,
+		})
+		w.StopHandshakeCapture()
+	}
+}
@@ -2,20 +2,29 @@ package modules
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/exec"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/evilsocket/bettercap-ng/core"
+	"github.com/evilsocket/bettercap-ng/eventbus"
+	"github.com/evilsocket/bettercap-ng/log"
 	"github.com/evilsocket/bettercap-ng/session"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
 
 	"github.com/olekukonko/tablewriter"
 )
@@ -26,6 +35,19 @@ const BROADCAST_MAC = "ff:ff:ff:ff:ff:ff"
 
 const MAC48Validator = "((?:[0-9A-Fa-f]{2}[:-]){5}(?:[0-9A-Fa-f]{2}))"
 
+// DefaultHopPeriod is how long the channel hopper dwells on each
+// channel before moving on, absent a user override.
+const DefaultHopPeriod = 120 * time.Millisecond
+
+// DefaultHopChannels is the set of 2.4 GHz and 5 GHz channels the
+// hopper walks when the user hasn't set a custom list.
+var DefaultHopChannels = []int{
+	1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13,
+	36, 40, 44, 48, 52, 56, 60, 64,
+	100, 104, 108, 112, 116, 120, 124, 128, 132, 136, 140,
+	149, 153, 157, 161, 165,
+}
+
 type WDiscovery struct {
 	session.SessionModule
 	Targets *WlanTargets
@@ -35,6 +57,73 @@ type WDiscovery struct {
 
 	Handle       *pcap.Handle
 	BroadcastMac []byte
+
+	// scanLock guards HopPeriod, HopChannels, CurrentChannel and the
+	// measuring/* fields below, which are written from command
+	// handlers and read/written from both BSScan (the capture
+	// goroutine) and channelHopper.
+	scanLock sync.Mutex
+
+	HopPeriod      time.Duration
+	HopChannels    []int
+	CurrentChannel int
+
+	measuring    bool
+	measureSSID  string
+	measureCount int
+
+	// handshakeLock guards HandshakeCapturing, HandshakePath, handshake,
+	// handshakeFile and handshakeWriter, which are written from command
+	// handlers (wlan.handshake.capture on/off, wlan.handshake.path,
+	// wlan.handshake set target) and read/written from the capture
+	// goroutine's HandshakeScan/autoStartHandshakeCapture, the same
+	// class of race scanLock guards against above.
+	handshakeLock sync.Mutex
+
+	HandshakeCapturing bool
+	HandshakePath      string
+
+	handshake       *eapolCapture
+	handshakeFile   *os.File
+	handshakeWriter *pcapgo.Writer
+
+	busLock    sync.Mutex
+	Bus        eventbus.EventBus
+	BusTopic   string
+	instanceID string
+	publishSeq uint64
+}
+
+// WlanSighting is the payload wlan.recon publishes onto the event
+// bus for every base station or client it sees, so another instance
+// subscribed to the same topic can merge it into its own WlanTargets
+// view.
+type WlanSighting struct {
+	Essid   string
+	BSSID   string
+	IsBS    bool
+	Channel int
+}
+
+// DefaultBusTopic is the topic wlan.recon publishes sightings to and
+// subscribes on, absent a user override.
+const DefaultBusTopic = "wlan.recon.sighting"
+
+// bridgeSessionEventsTopic is the topic every session.Events entry is
+// republished on by runSessionEventBridge, independently of the
+// wlan.recon-specific sightings published under BusTopic. Deauth
+// results (DeauthEvent), proxy script activity and anything else the
+// session emits all flow through here, so a subscriber sees the same
+// events the local REPL would.
+const bridgeSessionEventsTopic = "session.events"
+
+// sessionEventPayload is what runSessionEventBridge publishes for
+// every session.Events entry, so a subscriber can tell which kind of
+// event it's looking at (event.Tag) without needing wlan.recon's own
+// types.
+type sessionEventPayload struct {
+	Tag  string          `json:"tag"`
+	Data json.RawMessage `json:"data"`
 }
 
 func NewWDiscovery(s *session.Session) *WDiscovery {
@@ -42,6 +131,12 @@ func NewWDiscovery(s *session.Session) *WDiscovery {
 		SessionModule: session.NewSessionModule("wlan.recon", s),
 		ClientTarget:  make([]byte, 0),
 		BSTarget:      make([]byte, 0),
+		HopPeriod:     DefaultHopPeriod,
+		HopChannels:   DefaultHopChannels,
+		HandshakePath: DefaultHandshakePath,
+		Bus:           eventbus.NewLocalBus(),
+		BusTopic:      DefaultBusTopic,
+		instanceID:    randomInstanceID(),
 	}
 
 	w.AddHandler(session.NewModuleHandler("wlan.recon on", "",
@@ -104,9 +199,102 @@ func NewWDiscovery(s *session.Session) *WDiscovery {
 			return w.Show("essid")
 		}))
 
+	w.AddHandler(session.NewModuleHandler("wlan.recon set hop.dwell MS", `wlan\.recon set hop\.dwell (\d+)`,
+		"Set the per-channel dwell time in milliseconds for the channel hopper.",
+		func(args []string) error {
+			ms, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			w.scanLock.Lock()
+			w.HopPeriod = time.Duration(ms) * time.Millisecond
+			w.scanLock.Unlock()
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.recon set hop.channels CHANNELS", `wlan\.recon set hop\.channels (.+)`,
+		"Set a comma separated list of channels to hop through while scanning (e.g. 1,6,11).",
+		func(args []string) error {
+			channels := make([]int, 0)
+			for _, s := range strings.Split(args[0], ",") {
+				ch, err := strconv.Atoi(strings.TrimSpace(s))
+				if err != nil {
+					return err
+				}
+				channels = append(channels, ch)
+			}
+			w.scanLock.Lock()
+			w.HopChannels = channels
+			w.scanLock.Unlock()
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wlan.recon measure SSID SECONDS", `wlan\.recon measure (.+) (\d+)`,
+		"Lock to the current channel for SECONDS seconds and report how many beacons of SSID were observed (useful to validate hop.dwell).",
+		func(args []string) error {
+			secs, err := strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+			return w.MeasureDwell(args[0], time.Duration(secs)*time.Second)
+		}))
+
+	w.AddHandler(session.NewModuleHandler("events.bus set url URL", `events\.bus set url (.+)`,
+		"Point the event bus at a nats://, redis:// or local:// backend, so sightings can be shared with other bettercap instances (default: in-process only).",
+		func(args []string) error {
+			bus, err := eventbus.NewFromURL(args[0])
+			if err != nil {
+				return err
+			}
+
+			w.busLock.Lock()
+			old := w.Bus
+			w.Bus = bus
+			w.busLock.Unlock()
+
+			if old != nil {
+				old.Close()
+			}
+
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("events.bus subscribe TOPIC", `events\.bus subscribe (.+)`,
+		"Subscribe to TOPIC on the event bus and merge remote wlan.recon sightings into the current targets view.",
+		func(args []string) error {
+			return w.subscribeRemote(args[0])
+		}))
+
+	w.AddHandler(session.NewModuleHandler("events.bus replay TOPIC PUBLISHER SEQ", `events\.bus replay (\S+) (\S+) (\d+)`,
+		"Replay every event PUBLISHER sent on TOPIC after SEQ, to catch up on sightings published before this instance subscribed.",
+		func(args []string) error {
+			seq, err := strconv.ParseUint(args[2], 10, 64)
+			if err != nil {
+				return err
+			}
+			return w.replayRemote(args[0], args[1], seq)
+		}))
+
+	w.registerHandshakeHandlers()
+
+	go w.runSessionEventBridge()
+
 	return w
 }
 
+// randomInstanceID generates a short id this process tags every
+// event it publishes with, so a subscriber can tell its own sightings
+// apart from remote ones and request a replay of what it missed.
+func randomInstanceID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		// fall back to the current time rather than risk every
+		// instance sharing the same all-zero id
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%x", buf)
+}
+
 func (w WDiscovery) Name() string {
 	return "wlan.recon"
 }
@@ -124,7 +312,10 @@ func (w *WDiscovery) getRow(e *WlanEndpoint) []string {
 	sinceFirstSeen := time.Since(e.Endpoint.FirstSeen)
 
 	mac := e.Endpoint.HwAddress
-	if w.Targets.WasMissed(e.Endpoint.HwAddress) == true {
+	if e.RemoteSource != "" {
+		// seen by another instance over the event bus, not by us
+		mac = core.Dim(fmt.Sprintf("%s (via %s)", mac, e.RemoteSource))
+	} else if w.Targets.WasMissed(e.Endpoint.HwAddress) == true {
 		// if endpoint was not found at least once
 		mac = core.Dim(mac)
 	} else if sinceStarted > (justJoinedTimeInterval*2) && sinceFirstSeen <= justJoinedTimeInterval {
@@ -244,11 +435,41 @@ func (w *WDiscovery) Show(by string) error {
 	return nil
 }
 
+// setInterfaceChannel tunes iface to channel with "iw dev <iface> set
+// channel <channel>". Shared by every module that needs the
+// monitor-mode radio to actually be on the channel it claims to be on
+// (wlan.recon's hopper, wlan.ap's beacon loop).
+func setInterfaceChannel(iface string, channel int) error {
+	return exec.Command("iw", "dev", iface, "set", "channel", strconv.Itoa(channel)).Run()
+}
+
+// serializeDot11Frame wraps a Dot11 management/control layer (plus
+// any further payload layers) in a RadioTap header and serializes the
+// whole thing to bytes ready for pcap.Handle.WritePacketData. Shared
+// by every module that injects raw 802.11 frames (deauth, wlan.ap).
+func serializeDot11Frame(dot11Layer *layers.Dot11, payload ...gopacket.SerializableLayer) []byte {
+	var radioTapLayer layers.RadioTap
+
+	layerStack := make([]gopacket.SerializableLayer, 0, len(payload)+2)
+	layerStack = append(layerStack, &radioTapLayer, dot11Layer)
+	layerStack = append(layerStack, payload...)
+
+	buffer := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buffer,
+		gopacket.SerializeOptions{
+			ComputeChecksums: true,
+			FixLengths:       true,
+		},
+		layerStack...,
+	)
+
+	return buffer.Bytes()
+}
+
 func (w *WDiscovery) buildDeauthPkt(address1 net.HardwareAddr, address2 net.HardwareAddr, address3 net.HardwareAddr, _type layers.Dot11Type, reason layers.Dot11Reason, seq uint16) []byte {
 	var (
-		deauthLayer   layers.Dot11MgmtDeauthentication
-		dot11Layer    layers.Dot11
-		radioTapLayer layers.RadioTap
+		deauthLayer layers.Dot11MgmtDeauthentication
+		dot11Layer  layers.Dot11
 	)
 
 	deauthLayer.Reason = reason
@@ -259,24 +480,23 @@ func (w *WDiscovery) buildDeauthPkt(address1 net.HardwareAddr, address2 net.Hard
 	dot11Layer.Type = _type
 	dot11Layer.SequenceNumber = seq
 
-	buffer := gopacket.NewSerializeBuffer()
-	gopacket.SerializeLayers(buffer,
-		gopacket.SerializeOptions{
-			ComputeChecksums: true,
-			FixLengths:       true,
-		},
-		&radioTapLayer,
-		&dot11Layer,
-		&deauthLayer,
-	)
+	return serializeDot11Frame(&dot11Layer, &deauthLayer)
+}
 
-	return buffer.Bytes()
+// DeauthEvent is recorded to session.Events (and, through
+// runSessionEventBridge, the event bus) once a deauth burst against a
+// given base station/client pair completes.
+type DeauthEvent struct {
+	BSSID  string
+	Client string
 }
 
 func (w *WDiscovery) SendDeauthPacket(ap net.HardwareAddr, client net.HardwareAddr) {
 	var pkt []byte
 	var err error
 
+	w.autoStartHandshakeCapture(ap, client)
+
 	var seq uint16
 	for seq = 0; seq < 64; seq++ {
 		pkt = w.buildDeauthPkt(ap, client, ap, layers.Dot11TypeMgmtDeauthentication, layers.Dot11ReasonClass2FromNonAuth, seq)
@@ -295,6 +515,11 @@ func (w *WDiscovery) SendDeauthPacket(ap net.HardwareAddr, client net.HardwareAd
 
 		time.Sleep(2 * time.Millisecond)
 	}
+
+	w.Session.Events.Add("wlan.deauth", DeauthEvent{
+		BSSID:  ap.String(),
+		Client: client.String(),
+	})
 }
 
 func (w *WDiscovery) SendDeauth() error {
@@ -352,6 +577,24 @@ func (w *WDiscovery) BSScan(packet gopacket.Packet) {
 	if bytes.Compare(dst, w.BroadcastMac) == 0 && len(ssid) > 0 {
 		channel = WlanMhzToChannel(int(radiotap.ChannelFrequency))
 		w.Targets.AddIfNew(ssid, bssid, true, channel)
+		w.publish(WlanSighting{Essid: ssid, BSSID: bssid, IsBS: true, Channel: channel})
+
+		w.scanLock.Lock()
+		if w.measuring && ssid == w.measureSSID {
+			w.measureCount++
+		}
+		w.scanLock.Unlock()
+
+		w.handshakeLock.Lock()
+		capturing := w.HandshakeCapturing
+		target := w.handshake
+		w.handshakeLock.Unlock()
+
+		if capturing && target != nil && !target.beaconed && bssid == target.bssid.String() {
+			if err := w.writeHandshakeFrame(packet); err == nil {
+				target.beaconed = true
+			}
+		}
 	}
 }
 
@@ -385,10 +628,132 @@ func (w *WDiscovery) ClientScan(bs net.HardwareAddr, packet gopacket.Packet) {
 		if bytes.Compare(bssid, bs) == 0 {
 			channel := WlanMhzToChannel(int(radiotap.ChannelFrequency))
 			w.Targets.AddIfNew("", src.String(), false, channel)
+			w.publish(WlanSighting{BSSID: src.String(), IsBS: false, Channel: channel})
+		}
+	}
+}
+
+// publish emits sighting onto w.Bus under w.BusTopic, tagged with
+// this instance's id and the next sequence number in its own stream,
+// so other bettercap instances subscribed to the same topic can merge
+// it into their own view.
+func (w *WDiscovery) publish(sighting WlanSighting) {
+	w.busLock.Lock()
+	bus := w.Bus
+	w.busLock.Unlock()
+
+	if bus == nil {
+		return
+	}
+
+	seq := atomic.AddUint64(&w.publishSeq, 1)
+	if err := bus.Publish(w.BusTopic, w.instanceID, seq, sighting); err != nil {
+		log.Debug("could not publish wlan sighting on event bus: %s", err)
+	}
+}
+
+// runSessionEventBridge forwards every session.Events entry onto the
+// bus under bridgeSessionEventsTopic for as long as the process runs,
+// independently of wlan.recon's own Start/Stop, the same way the bus
+// itself is set up once in NewWDiscovery. This is what gets deauth
+// results (DeauthEvent) and http.proxy script activity onto the bus,
+// not just wlan.recon sightings.
+func (w *WDiscovery) runSessionEventBridge() {
+	listener := w.Session.Events.Listen()
+	defer w.Session.Events.Unlisten(listener)
+
+	for event := range listener {
+		dataJSON, err := json.Marshal(event.Data)
+		if err != nil {
+			log.Debug("could not encode session event %s for the event bus: %s", event.Tag, err)
+			continue
+		}
+
+		w.busLock.Lock()
+		bus := w.Bus
+		w.busLock.Unlock()
+
+		if bus == nil {
+			continue
+		}
+
+		seq := atomic.AddUint64(&w.publishSeq, 1)
+		payload := sessionEventPayload{Tag: event.Tag, Data: dataJSON}
+		if err := bus.Publish(bridgeSessionEventsTopic, w.instanceID, seq, payload); err != nil {
+			log.Debug("could not publish session event %s on event bus: %s", event.Tag, err)
 		}
 	}
 }
 
+// mergeRemoteEvent decodes a WlanSighting out of event and folds it
+// into w.Targets, tagged with the publisher's id so getRow can show
+// where it came from. Sightings this instance published itself (e.g.
+// echoed back by a shared backend) are ignored.
+func (w *WDiscovery) mergeRemoteEvent(event eventbus.Event) {
+	if event.PublisherID == w.instanceID {
+		return
+	}
+
+	var sighting WlanSighting
+	if err := json.Unmarshal(event.Data, &sighting); err != nil {
+		log.Warning("could not decode remote wlan sighting: %s", err)
+		return
+	}
+
+	if w.Targets != nil {
+		w.Targets.AddIfNewRemote(sighting.Essid, sighting.BSSID, sighting.IsBS, sighting.Channel, event.PublisherID)
+	}
+}
+
+// subscribeRemote subscribes to topic on w.Bus and merges every
+// sighting published by other instances into w.Targets as they
+// arrive. A subscriber that joined late and wants to catch up on a
+// specific publisher's history can follow up with
+// "events.bus replay", which uses the same sequence numbers.
+func (w *WDiscovery) subscribeRemote(topic string) error {
+	w.busLock.Lock()
+	bus := w.Bus
+	w.busLock.Unlock()
+
+	if bus == nil {
+		return errors.New("event bus is not configured, use 'events.bus set url' first")
+	}
+
+	ch, err := bus.Subscribe(topic)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for event := range ch {
+			w.mergeRemoteEvent(event)
+		}
+	}()
+
+	return nil
+}
+
+// replayRemote asks w.Bus to replay every event published on topic by
+// publisherID after seq, merging each one into w.Targets. This lets a
+// subscriber that joined after sightings were published (or that
+// noticed a gap in a publisher's sequence numbers) catch up from the
+// backend's buffered history instead of waiting to see them live.
+func (w *WDiscovery) replayRemote(topic string, publisherID string, seq uint64) error {
+	w.busLock.Lock()
+	bus := w.Bus
+	w.busLock.Unlock()
+
+	if bus == nil {
+		return errors.New("event bus is not configured, use 'events.bus set url' first")
+	}
+
+	for _, event := range bus.Since(topic, publisherID, seq) {
+		w.mergeRemoteEvent(event)
+	}
+
+	return nil
+}
+
 func (w *WDiscovery) Configure() error {
 	var err error
 
@@ -426,6 +791,8 @@ func (w *WDiscovery) Start() error {
 		return err
 	}
 
+	go w.channelHopper()
+
 	w.SetRunning(true, func() {
 		defer w.Handle.Close()
 		src := gopacket.NewPacketSource(w.Handle, w.Handle.LinkType())
@@ -439,12 +806,115 @@ func (w *WDiscovery) Start() error {
 			} else {
 				w.BSScan(packet)
 			}
+
+			w.HandshakeScan(packet)
 		}
 	})
 
 	return nil
 }
 
+// setChannel tunes the monitor-mode interface to the given channel.
+func (w *WDiscovery) setChannel(channel int) {
+	iface := w.Session.Interface.Name()
+	if err := setInterfaceChannel(iface, channel); err != nil {
+		log.Warning("Could not set %s to channel %d: %s", iface, channel, err)
+		return
+	}
+
+	w.scanLock.Lock()
+	w.CurrentChannel = channel
+	w.scanLock.Unlock()
+}
+
+// lockedChannel returns the last-seen channel of the current BSTarget,
+// or 0 if it is not set or hasn't been seen yet.
+func (w *WDiscovery) lockedChannel() int {
+	if len(w.BSTarget) == 0 || w.Targets == nil {
+		return 0
+	}
+
+	bssid := w.BSTarget.String()
+	for _, t := range w.Targets.List() {
+		if t.Endpoint.HwAddress == bssid {
+			return t.Channel
+		}
+	}
+
+	return 0
+}
+
+// channelHopper walks HopChannels at HopPeriod intervals for as long
+// as wlan.recon is running, locking to the BSTarget's channel instead
+// of hopping once one is set, and pausing entirely while a dwell
+// measurement is in progress.
+func (w *WDiscovery) channelHopper() {
+	idx := 0
+
+	for w.Running() {
+		w.scanLock.Lock()
+		measuring := w.measuring
+		period := w.HopPeriod
+		current := w.CurrentChannel
+		channels := w.HopChannels
+		w.scanLock.Unlock()
+
+		if measuring {
+			time.Sleep(period)
+			continue
+		}
+
+		if locked := w.lockedChannel(); locked > 0 {
+			if current != locked {
+				w.setChannel(locked)
+			}
+		} else if len(channels) > 0 {
+			w.setChannel(channels[idx%len(channels)])
+			idx++
+		}
+
+		time.Sleep(period)
+	}
+}
+
+// MeasureDwell locks the current channel for duration and reports how
+// many beacons of ssid were observed, to let users validate that the
+// card is actually spending hop.dwell worth of time per channel.
+func (w *WDiscovery) MeasureDwell(ssid string, duration time.Duration) error {
+	if w.Running() == false {
+		return errors.New("wlan.recon is not running.")
+	}
+
+	w.scanLock.Lock()
+	if w.measuring {
+		w.scanLock.Unlock()
+		return errors.New("A dwell measurement is already in progress.")
+	}
+	w.measuring = true
+	w.measureSSID = ssid
+	w.measureCount = 0
+	current := w.CurrentChannel
+	w.scanLock.Unlock()
+
+	defer func() {
+		w.scanLock.Lock()
+		w.measuring = false
+		w.scanLock.Unlock()
+	}()
+
+	fmt.Printf("Measuring beacons for %s on channel %d for %s ...\n", ssid, current, duration)
+
+	time.Sleep(duration)
+
+	w.scanLock.Lock()
+	count := w.measureCount
+	w.scanLock.Unlock()
+
+	fmt.Printf("Captured %d beacon(s) for %s in %s.\n", count, ssid, duration)
+
+	return nil
+}
+
 func (w *WDiscovery) Stop() error {
 	return w.SetRunning(false, nil)
 }
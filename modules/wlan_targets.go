@@ -0,0 +1,148 @@
+package modules
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/bettercap-ng/session"
+)
+
+// WlanEndpoint wraps a session.Endpoint with the 802.11-specific
+// state wlan.recon tracks for it: which SSID it was seen broadcasting
+// and on which channel. RemoteSource is set when the sighting came
+// from another bettercap instance over the event bus rather than from
+// this one's own capture, so getRow can render it accordingly.
+type WlanEndpoint struct {
+	Endpoint     *session.Endpoint
+	Essid        string
+	Channel      int
+	RemoteSource string
+}
+
+// WlanTargets is wlan.recon's view of every base station or client
+// seen so far, keyed by MAC address.
+type WlanTargets struct {
+	session *session.Session
+	iface   *session.Endpoint
+
+	lock    sync.Mutex
+	Targets map[string]*WlanEndpoint
+	missed  map[string]bool
+}
+
+// NewWlanTargets creates an empty targets view for s, tagging any
+// endpoint matching iface as the local interface (see getRow).
+func NewWlanTargets(s *session.Session, iface *session.Endpoint) *WlanTargets {
+	return &WlanTargets{
+		session: s,
+		iface:   iface,
+		Targets: make(map[string]*WlanEndpoint),
+		missed:  make(map[string]bool),
+	}
+}
+
+// List returns a snapshot of every known endpoint.
+func (t *WlanTargets) List() []*WlanEndpoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	list := make([]*WlanEndpoint, 0, len(t.Targets))
+	for _, e := range t.Targets {
+		list = append(list, e)
+	}
+	return list
+}
+
+// WasMissed reports whether mac was previously tracked and then
+// dropped, e.g. by ClearAll when the base station filter changes.
+func (t *WlanTargets) WasMissed(mac string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.missed[mac]
+}
+
+// ClearAll drops every tracked endpoint, remembering their MACs as
+// missed so a stale row can still be told apart from one never seen.
+func (t *WlanTargets) ClearAll() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for mac := range t.Targets {
+		t.missed[mac] = true
+	}
+	t.Targets = make(map[string]*WlanEndpoint)
+}
+
+// newEndpoint builds the session.Endpoint backing a freshly seen MAC,
+// reusing the local interface's own endpoint when mac is its address.
+func (t *WlanTargets) newEndpoint(mac string) *session.Endpoint {
+	if t.iface != nil && t.iface.HwAddress == mac {
+		return t.iface
+	}
+
+	hw, _ := net.ParseMAC(mac)
+	now := time.Now()
+
+	return &session.Endpoint{
+		HW:        hw,
+		HwAddress: mac,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+}
+
+// AddIfNew records a local sighting of bssid (an essid-advertising
+// base station if isBS, otherwise a client), creating a new
+// WlanEndpoint the first time it's seen and refreshing LastSeen and
+// the channel otherwise.
+func (t *WlanTargets) AddIfNew(ssid string, bssid string, isBS bool, channel int) *WlanEndpoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if e, found := t.Targets[bssid]; found {
+		e.Endpoint.LastSeen = time.Now()
+		e.Channel = channel
+		if ssid != "" {
+			e.Essid = ssid
+		}
+		return e
+	}
+
+	e := &WlanEndpoint{
+		Endpoint: t.newEndpoint(bssid),
+		Essid:    ssid,
+		Channel:  channel,
+	}
+	t.Targets[bssid] = e
+
+	return e
+}
+
+// AddIfNewRemote is AddIfNew for a sighting published by another
+// bettercap instance over the event bus: the resulting WlanEndpoint is
+// tagged with remoteSource instead of refreshing LastSeen from a local
+// capture.
+func (t *WlanTargets) AddIfNewRemote(ssid string, bssid string, isBS bool, channel int, remoteSource string) *WlanEndpoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if e, found := t.Targets[bssid]; found {
+		e.Channel = channel
+		e.RemoteSource = remoteSource
+		if ssid != "" {
+			e.Essid = ssid
+		}
+		return e
+	}
+
+	e := &WlanEndpoint{
+		Endpoint:     t.newEndpoint(bssid),
+		Essid:        ssid,
+		Channel:      channel,
+		RemoteSource: remoteSource,
+	}
+	t.Targets[bssid] = e
+
+	return e
+}
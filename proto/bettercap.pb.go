@@ -0,0 +1,477 @@
+// Hand-written to match the shape protoc-gen-go --plugins=grpc would
+// produce for proto/bettercap.proto (protoc is not available in every
+// build environment this tree is developed in). This is NOT the
+// output of a real protoc run: there is no embedded file descriptor
+// and no proto.RegisterFile call, so reflection-based proto APIs
+// (proto.Marshal/Unmarshal still work; anything needing the
+// descriptor, e.g. grpc-gateway, will not). If protoc becomes
+// available, regenerate for real with:
+//
+//	protoc --go_out=plugins=grpc:. proto/bettercap.proto
+//
+// and replace this file with the genuine output.
+// source: proto/bettercap.proto
+
+package proto
+
+import (
+	context "context"
+	fmt "fmt"
+	math "math"
+
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Empty struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Empty) Reset()         { *m = Empty{} }
+func (m *Empty) String() string { return proto.CompactTextString(m) }
+func (*Empty) ProtoMessage()    {}
+
+type Module struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Description          string   `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	Author               string   `protobuf:"bytes,3,opt,name=author,proto3" json:"author,omitempty"`
+	Running              bool     `protobuf:"varint,4,opt,name=running,proto3" json:"running,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Module) Reset()         { *m = Module{} }
+func (m *Module) String() string { return proto.CompactTextString(m) }
+func (*Module) ProtoMessage()    {}
+
+func (m *Module) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Module) GetDescription() string {
+	if m != nil {
+		return m.Description
+	}
+	return ""
+}
+
+func (m *Module) GetAuthor() string {
+	if m != nil {
+		return m.Author
+	}
+	return ""
+}
+
+func (m *Module) GetRunning() bool {
+	if m != nil {
+		return m.Running
+	}
+	return false
+}
+
+type ModuleList struct {
+	Modules              []*Module `protobuf:"bytes,1,rep,name=modules,proto3" json:"modules,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ModuleList) Reset()         { *m = ModuleList{} }
+func (m *ModuleList) String() string { return proto.CompactTextString(m) }
+func (*ModuleList) ProtoMessage()    {}
+
+func (m *ModuleList) GetModules() []*Module {
+	if m != nil {
+		return m.Modules
+	}
+	return nil
+}
+
+type CommandRequest struct {
+	Command              string   `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CommandRequest) Reset()         { *m = CommandRequest{} }
+func (m *CommandRequest) String() string { return proto.CompactTextString(m) }
+func (*CommandRequest) ProtoMessage()    {}
+
+func (m *CommandRequest) GetCommand() string {
+	if m != nil {
+		return m.Command
+	}
+	return ""
+}
+
+type CommandResponse struct {
+	Success              bool     `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error                string   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CommandResponse) Reset()         { *m = CommandResponse{} }
+func (m *CommandResponse) String() string { return proto.CompactTextString(m) }
+func (*CommandResponse) ProtoMessage()    {}
+
+func (m *CommandResponse) GetSuccess() bool {
+	if m != nil {
+		return m.Success
+	}
+	return false
+}
+
+func (m *CommandResponse) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type EventFilter struct {
+	// Only events whose tag starts with this prefix are streamed.
+	// Empty means every event.
+	TagPrefix            string   `protobuf:"bytes,1,opt,name=tag_prefix,json=tagPrefix,proto3" json:"tag_prefix,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EventFilter) Reset()         { *m = EventFilter{} }
+func (m *EventFilter) String() string { return proto.CompactTextString(m) }
+func (*EventFilter) ProtoMessage()    {}
+
+func (m *EventFilter) GetTagPrefix() string {
+	if m != nil {
+		return m.TagPrefix
+	}
+	return ""
+}
+
+type Event struct {
+	Tag  string `protobuf:"bytes,1,opt,name=tag,proto3" json:"tag,omitempty"`
+	Time string `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	// JSON-serialized session.Event.Data, so clients don't need the Go
+	// struct definitions to consume the stream.
+	DataJson             string   `protobuf:"bytes,3,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+
+func (m *Event) GetTag() string {
+	if m != nil {
+		return m.Tag
+	}
+	return ""
+}
+
+func (m *Event) GetTime() string {
+	if m != nil {
+		return m.Time
+	}
+	return ""
+}
+
+func (m *Event) GetDataJson() string {
+	if m != nil {
+		return m.DataJson
+	}
+	return ""
+}
+
+type ProxyScriptRequest struct {
+	// Either path or source must be set; path is resolved and read
+	// server-side, source is compiled as-is.
+	Path                 string   `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Source               string   `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ProxyScriptRequest) Reset()         { *m = ProxyScriptRequest{} }
+func (m *ProxyScriptRequest) String() string { return proto.CompactTextString(m) }
+func (*ProxyScriptRequest) ProtoMessage()    {}
+
+func (m *ProxyScriptRequest) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *ProxyScriptRequest) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+type ProxyScriptResponse struct {
+	Success              bool     `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error                string   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ProxyScriptResponse) Reset()         { *m = ProxyScriptResponse{} }
+func (m *ProxyScriptResponse) String() string { return proto.CompactTextString(m) }
+func (*ProxyScriptResponse) ProtoMessage()    {}
+
+func (m *ProxyScriptResponse) GetSuccess() bool {
+	if m != nil {
+		return m.Success
+	}
+	return false
+}
+
+func (m *ProxyScriptResponse) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*Empty)(nil), "bettercap.Empty")
+	proto.RegisterType((*Module)(nil), "bettercap.Module")
+	proto.RegisterType((*ModuleList)(nil), "bettercap.ModuleList")
+	proto.RegisterType((*CommandRequest)(nil), "bettercap.CommandRequest")
+	proto.RegisterType((*CommandResponse)(nil), "bettercap.CommandResponse")
+	proto.RegisterType((*EventFilter)(nil), "bettercap.EventFilter")
+	proto.RegisterType((*Event)(nil), "bettercap.Event")
+	proto.RegisterType((*ProxyScriptRequest)(nil), "bettercap.ProxyScriptRequest")
+	proto.RegisterType((*ProxyScriptResponse)(nil), "bettercap.ProxyScriptResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// BettercapClient is the client API for Bettercap service.
+type BettercapClient interface {
+	// ListModules returns every registered module's name, description,
+	// author and running state; its command handlers are not included.
+	ListModules(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ModuleList, error)
+	// RunCommand dispatches a single REPL command line through
+	// session.ModuleHandler, exactly as if it had been typed interactively.
+	RunCommand(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (*CommandResponse, error)
+	// Subscribe streams session.Events as they're fired, optionally
+	// filtered by tag prefix, for as long as the client stays connected.
+	Subscribe(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (Bettercap_SubscribeClient, error)
+	// LoadProxyScript compiles and hot-loads a ProxyScript into a
+	// running http.proxy instance without restarting it.
+	LoadProxyScript(ctx context.Context, in *ProxyScriptRequest, opts ...grpc.CallOption) (*ProxyScriptResponse, error)
+}
+
+type bettercapClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewBettercapClient(cc *grpc.ClientConn) BettercapClient {
+	return &bettercapClient{cc}
+}
+
+func (c *bettercapClient) ListModules(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ModuleList, error) {
+	out := new(ModuleList)
+	if err := c.cc.Invoke(ctx, "/bettercap.Bettercap/ListModules", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bettercapClient) RunCommand(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (*CommandResponse, error) {
+	out := new(CommandResponse)
+	if err := c.cc.Invoke(ctx, "/bettercap.Bettercap/RunCommand", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bettercapClient) Subscribe(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (Bettercap_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Bettercap_serviceDesc.Streams[0], "/bettercap.Bettercap/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bettercapSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Bettercap_SubscribeClient is the client-side stream handle returned
+// by BettercapClient.Subscribe.
+type Bettercap_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type bettercapSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *bettercapSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bettercapClient) LoadProxyScript(ctx context.Context, in *ProxyScriptRequest, opts ...grpc.CallOption) (*ProxyScriptResponse, error) {
+	out := new(ProxyScriptResponse)
+	if err := c.cc.Invoke(ctx, "/bettercap.Bettercap/LoadProxyScript", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BettercapServer is the server API for Bettercap service.
+type BettercapServer interface {
+	// ListModules returns every registered module's name, description,
+	// author and running state; its command handlers are not included.
+	ListModules(context.Context, *Empty) (*ModuleList, error)
+	// RunCommand dispatches a single REPL command line through
+	// session.ModuleHandler, exactly as if it had been typed interactively.
+	RunCommand(context.Context, *CommandRequest) (*CommandResponse, error)
+	// Subscribe streams session.Events as they're fired, optionally
+	// filtered by tag prefix, for as long as the client stays connected.
+	Subscribe(*EventFilter, Bettercap_SubscribeServer) error
+	// LoadProxyScript compiles and hot-loads a ProxyScript into a
+	// running http.proxy instance without restarting it.
+	LoadProxyScript(context.Context, *ProxyScriptRequest) (*ProxyScriptResponse, error)
+}
+
+// RegisterBettercapServer registers srv with s so incoming Bettercap
+// RPCs are dispatched to it.
+func RegisterBettercapServer(s *grpc.Server, srv BettercapServer) {
+	s.RegisterService(&_Bettercap_serviceDesc, srv)
+}
+
+func _Bettercap_ListModules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BettercapServer).ListModules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bettercap.Bettercap/ListModules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BettercapServer).ListModules(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bettercap_RunCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommandRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BettercapServer).RunCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bettercap.Bettercap/RunCommand",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BettercapServer).RunCommand(ctx, req.(*CommandRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bettercap_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BettercapServer).Subscribe(m, &bettercapSubscribeServer{stream})
+}
+
+// Bettercap_SubscribeServer is the server-side stream handle passed to
+// BettercapServer.Subscribe.
+type Bettercap_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type bettercapSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *bettercapSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Bettercap_LoadProxyScript_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProxyScriptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BettercapServer).LoadProxyScript(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bettercap.Bettercap/LoadProxyScript",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BettercapServer).LoadProxyScript(ctx, req.(*ProxyScriptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Bettercap_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "bettercap.Bettercap",
+	HandlerType: (*BettercapServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListModules",
+			Handler:    _Bettercap_ListModules_Handler,
+		},
+		{
+			MethodName: "RunCommand",
+			Handler:    _Bettercap_RunCommand_Handler,
+		},
+		{
+			MethodName: "LoadProxyScript",
+			Handler:    _Bettercap_LoadProxyScript_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Bettercap_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/bettercap.proto",
+}